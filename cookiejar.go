@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const cookie_jar_filename = "cookies.json"
+
+type stored_cookie struct {
+	Name   string    `json:"name"`
+	Value  string    `json:"value"`
+	Domain string    `json:"domain"`
+	Expiry time.Time `json:"expiry"`
+}
+
+// returns the path of the on-disk cookie jar, e.g.
+// ~/.cache/zabbix-report-batch/cookies.json
+func cookie_jar_path() string {
+	cache_dir, err := os.UserCacheDir()
+
+	if err != nil {
+		cache_dir = "."
+	}
+
+	return filepath.Join(cache_dir, "zabbix-report-batch", cookie_jar_filename)
+}
+
+// builds an in-memory http.CookieJar, pre-populated from the on-disk
+// store (if any) so a still-valid zbx_session survives across runs
+func load_cookie_jar(zabbix_url string) (http.CookieJar, error) {
+	jar, err := cookiejar.New(nil)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the cookie jar, error: %w", err)
+	}
+
+	path := cookie_jar_path()
+	content, err := os.ReadFile(path)
+
+	if err != nil {
+		// nothing stored yet, start with an empty jar
+		return jar, nil
+	}
+
+	var stored_cookies []stored_cookie
+	err = json.Unmarshal(content, &stored_cookies)
+
+	if err != nil {
+		fmt.Printf("failed to parse %s, ignoring stored cookies, error: %s\n", path, err.Error())
+		return jar, nil
+	}
+
+	parsed_url, err := url.Parse(zabbix_url)
+
+	if err != nil {
+		return jar, nil
+	}
+
+	cookies := make([]*http.Cookie, 0, len(stored_cookies))
+
+	for _, sc := range stored_cookies {
+		cookies = append(cookies, &http.Cookie{
+			Name:    sc.Name,
+			Value:   sc.Value,
+			Domain:  sc.Domain,
+			Expires: sc.Expiry,
+		})
+	}
+
+	jar.SetCookies(parsed_url, cookies)
+
+	return jar, nil
+}
+
+// serializes the jar's cookies scoped to zabbix_url back to disk with
+// 0600 perms, so the next run can skip zabbix_login entirely. http's
+// cookiejar.Jar only hands Name/Value back out of Cookies() (it's built to
+// populate a Cookie: header, nothing more), so session_expiry - captured by
+// the caller straight off the zbx_session Set-Cookie header - is used for
+// that cookie instead of whatever Jar.Cookies() reports
+func save_cookie_jar(jar http.CookieJar, zabbix_url string, session_expiry time.Time) error {
+	parsed_url, err := url.Parse(zabbix_url)
+
+	if err != nil {
+		return fmt.Errorf("failed to parse %s, error: %w", zabbix_url, err)
+	}
+
+	stored_cookies := make([]stored_cookie, 0)
+
+	for _, cookie := range jar.Cookies(parsed_url) {
+		expiry := cookie.Expires
+
+		if cookie.Name == "zbx_session" {
+			expiry = session_expiry
+		}
+
+		stored_cookies = append(stored_cookies, stored_cookie{
+			Name:   cookie.Name,
+			Value:  cookie.Value,
+			Domain: parsed_url.Hostname(),
+			Expiry: expiry,
+		})
+	}
+
+	content, err := json.Marshal(stored_cookies)
+
+	if err != nil {
+		return fmt.Errorf("failed to encode the cookie jar, error: %w", err)
+	}
+
+	path := cookie_jar_path()
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+
+	if err != nil {
+		return fmt.Errorf("failed to create %s, error: %w", filepath.Dir(path), err)
+	}
+
+	return os.WriteFile(path, content, 0600)
+}
+
+// true when the jar already holds a zbx_session cookie for zabbix_url
+func has_valid_session(jar http.CookieJar, zabbix_url string) bool {
+	parsed_url, err := url.Parse(zabbix_url)
+
+	if err != nil {
+		return false
+	}
+
+	for _, cookie := range jar.Cookies(parsed_url) {
+		if cookie.Name == "zbx_session" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// removes the on-disk cookie jar, used by --logout
+func clear_cookie_jar() error {
+	err := os.Remove(cookie_jar_path())
+
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"bytes"
+	"encoding/base64"
 	"encoding/csv"
+	"flag"
 	"fmt"
 	"io"
 	"net/http"
@@ -11,6 +13,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/bizxeon/zabbix-report-batch/zabbix"
 	"gopkg.in/yaml.v2"
 )
 
@@ -24,12 +27,32 @@ const (
 	ExitCodeErrorExportRequestBuilder = 6
 	ExitCodeErrorMissingCookie        = 7
 	ExitCodeErrorExportRequest        = 8
+	ExitCodeErrorCookieJar            = 9
+	ExitCodeErrorSMTPConnect          = 10
+	ExitCodeErrorSMTPSend             = 11
 )
 
+var (
+	flag_logout      = flag.Bool("logout", false, "clear the stored Zabbix session cookie and exit")
+	flag_force_login = flag.Bool("force-login", false, "ignore the stored session cookie and force a fresh login")
+)
+
+type GraphConfig struct {
+	Enabled           bool `yaml:"enabled"`
+	Width             int  `yaml:"width"`
+	Height            int  `yaml:"height"`
+	FromOffsetMinutes int  `yaml:"from_offset_minutes"`
+}
+
 type Config struct {
-	ZabbixUsername string `yaml:"zabbix_username"`
-	ZabbixPassword string `yaml:"zabbix_password"`
-	ZabbixUrl      string `yaml:"zabbix_url"`
+	ZabbixUsername string       `yaml:"zabbix_username"`
+	ZabbixPassword string       `yaml:"zabbix_password"`
+	ZabbixUrl      string       `yaml:"zabbix_url"`
+	Graphs         GraphConfig  `yaml:"graphs"`
+	Smtp           *SMTPConfig  `yaml:"smtp"`
+	RequestTimeout string       `yaml:"request_timeout"`
+	Mode           string       `yaml:"mode"` // "csv" (default) or "api"
+	Filters        FilterConfig `yaml:"filters"`
 }
 
 const config_filename = "config.yaml"
@@ -57,9 +80,12 @@ func load_config() Config {
 	return config
 }
 
-// returns a session cookie on successful login, otherwise it will
-// exits the program with the proper error code
-func zabbix_login(zabbix_url string, uname string, pass string) *http.Cookie {
+// logs into zabbix_url and lets client's cookie jar capture the
+// zbx_session cookie; exits the program with the proper error code on
+// failure, otherwise the caller is expected to persist the jar. returns
+// the zbx_session cookie's expiry straight off the Set-Cookie header,
+// since http.CookieJar doesn't expose it back out once stored
+func zabbix_login(client *http.Client, policy RetryPolicy, zabbix_url string, uname string, pass string) time.Time {
 	// encode the username, and password in case they contain unallowed characters
 	uname = url.QueryEscape(uname)
 	pass = url.QueryEscape(pass)
@@ -79,15 +105,7 @@ func zabbix_login(zabbix_url string, uname string, pass string) *http.Cookie {
 
 	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	client := http.Client{}
-
-	// prevent a redirect, golang doesn't store the cookie so during natural redirect we cause the server to respond
-	// with a cookie which with it we cannot access the zabbix as a user
-	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
-		return http.ErrUseLastResponse
-	}
-
-	response, err := client.Do(request)
+	response, err := do_with_retry(client, request, policy)
 
 	if err != nil {
 		fmt.Printf("failed to login, error: %s\n", err.Error())
@@ -99,23 +117,27 @@ func zabbix_login(zabbix_url string, uname string, pass string) *http.Cookie {
 	if response.StatusCode != http.StatusFound {
 		fmt.Printf("wrong credentials, server responded didn't return the session cookie\n")
 		os.Exit(ExitCodeErrorWrongCredentials)
-		return nil
+	}
+
+	if !has_valid_session(client.Jar, zabbix_url) {
+		fmt.Printf("server didn't return a session cookie\n")
+		os.Exit(ExitCodeErrorMissingCookie)
 	}
 
 	for _, cookie := range response.Cookies() {
 		if cookie.Name == "zbx_session" {
-			return cookie
+			return cookie.Expires
 		}
 	}
 
-	fmt.Printf("server didn't return a session cookie\n")
-	os.Exit(ExitCodeErrorMissingCookie)
-	return nil
+	return time.Time{}
 }
 
-// it will click the button export CSV and return the content of the file, on
+// it will click the button export CSV and return the content of the file. if
+// the session has expired the server redirects back to /index.php, in which
+// case needs_login is true and csv_content should be ignored. on any other
 // error it will exit with the proper error code
-func zabbix_export_csv(zabbix_url string, session_cookie *http.Cookie) string {
+func zabbix_export_csv(client *http.Client, policy RetryPolicy, zabbix_url string) (csv_content string, needs_login bool) {
 	request, err := http.NewRequest(http.MethodGet, zabbix_url+"/zabbix.php?action=problem.view.csv", bytes.NewBuffer([]byte{}))
 
 	if err != nil {
@@ -123,27 +145,40 @@ func zabbix_export_csv(zabbix_url string, session_cookie *http.Cookie) string {
 		os.Exit(ExitCodeErrorExportRequestBuilder)
 	}
 
-	request.AddCookie(session_cookie)
-
-	client := http.Client{}
-	response, err := client.Do(request)
+	response, err := do_with_retry(client, request, policy)
 
 	if err != nil {
 		fmt.Printf("failed to export the CSV, error: %s\n", err.Error())
 		os.Exit(ExitCodeErrorExportRequest)
 	}
 
+	defer response.Body.Close()
+
+	if response.StatusCode >= 300 && response.StatusCode < 400 && strings.Contains(response.Header.Get("Location"), "index.php") {
+		return "", true
+	}
+
 	body, _ := io.ReadAll(response.Body)
 
-	return string(body)
+	return string(body), false
 }
 
-// extract only the active problems from the CSV file
-func extract_active_problems(csv_content string) string {
+// extract only the active problems from the CSV file, embedding a graph
+// image per row when graphs.Enabled is set in config.yaml. when use_cid is
+// set the images are appended to attachments and referenced as cid: instead
+// of being inlined as data URIs, so an SMTP multipart/related message can
+// carry them as proper attachments
+func extract_active_problems(csv_content string, zabbix_url string, client *http.Client, policy RetryPolicy, itemids map[string]string, graphs GraphConfig, use_cid bool, attachments *[]email_attachment) string {
 	result := bytes.NewBufferString("")
 	csv_reader := csv.NewReader(strings.NewReader(csv_content))
 
-	fmt.Fprintf(result, "<table><tr><td style=\"text-align: center;\">Host</td><td style=\"text-align: center;\">Problem</td><td style=\"text-align: center;\">Time</td><td style=\"text-align: center;\">Duratiom</td></tr>\n")
+	fmt.Fprintf(result, "<table><tr><td style=\"text-align: center;\">Host</td><td style=\"text-align: center;\">Problem</td><td style=\"text-align: center;\">Time</td><td style=\"text-align: center;\">Duratiom</td>")
+
+	if graphs.Enabled {
+		fmt.Fprintf(result, "<td style=\"text-align: center;\">Graph</td>")
+	}
+
+	fmt.Fprintf(result, "</tr>\n")
 
 	for {
 		record, err := csv_reader.Read()
@@ -162,7 +197,13 @@ func extract_active_problems(csv_content string) string {
 			continue
 		}
 
-		fmt.Fprintf(result, "<tr><td>%s</td><td>%s</td><td>%s</td><td>%s</td></tr>\n", host, problem, time, duration)
+		fmt.Fprintf(result, "<tr><td>%s</td><td>%s</td><td>%s</td><td>%s</td>", host, problem, time, duration)
+
+		if graphs.Enabled {
+			fmt.Fprintf(result, "<td>%s</td>", graph_image_tag(zabbix_url, client, policy, itemids, host, problem, graphs, use_cid, attachments))
+		}
+
+		fmt.Fprintf(result, "</tr>\n")
 	}
 
 	fmt.Fprintf(result, "</table>")
@@ -170,6 +211,40 @@ func extract_active_problems(csv_content string) string {
 	return result.String()
 }
 
+// resolves the itemid for a host/problem pair, fetches its graph and
+// returns an <img> tag. when use_cid is set the PNG is appended to
+// attachments and the tag references it as cid:, otherwise it's inlined as
+// a data URI. returns an empty string when the itemid or the graph itself
+// could not be resolved
+func graph_image_tag(zabbix_url string, client *http.Client, policy RetryPolicy, itemids map[string]string, host string, problem string, graphs GraphConfig, use_cid bool, attachments *[]email_attachment) string {
+	itemid, ok := itemids[host+"|"+problem]
+
+	if !ok {
+		return ""
+	}
+
+	to := time.Now()
+	from := to.Add(-time.Duration(graphs.FromOffsetMinutes) * time.Minute)
+
+	png, err := zabbix_fetch_graph(client, policy, zabbix_url, itemid, from, to, graphs.Width, graphs.Height)
+
+	if err != nil {
+		fmt.Printf("failed to fetch the graph for %s/%s, error: %s\n", host, problem, err.Error())
+		return ""
+	}
+
+	if use_cid {
+		content_id := fmt.Sprintf("graph-%d@zabbix-report-batch", len(*attachments))
+		*attachments = append(*attachments, email_attachment{ContentID: content_id, Data: png})
+
+		return fmt.Sprintf("<img src=\"cid:%s\">", content_id)
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(png)
+
+	return fmt.Sprintf("<img src=\"data:image/png;base64,%s\">", encoded)
+}
+
 // extract only the resolved problems from the CSV file
 func extract_resolved_problems(csv_content string) string {
 	result := bytes.NewBufferString("")
@@ -203,17 +278,109 @@ func extract_resolved_problems(csv_content string) string {
 }
 
 func main() {
+	flag.Parse()
+
+	if *flag_logout {
+		err := clear_cookie_jar()
+
+		if err != nil {
+			fmt.Printf("failed to clear the cookie jar, error: %s\n", err.Error())
+			os.Exit(ExitCodeErrorCookieJar)
+		}
+
+		fmt.Printf("logged out, stored cookies removed\n")
+		os.Exit(ExitCodeSuccess)
+	}
+
 	config := load_config()
 
 	fmt.Printf("zabbix_username: %s\n", config.ZabbixUsername)
 	fmt.Printf("zabbix_password: %s\n", strings.Repeat("*", len(config.ZabbixPassword)))
 	fmt.Printf("zabbix_url: %s\n", config.ZabbixUrl)
 
-	session_cookie := zabbix_login(config.ZabbixUrl, config.ZabbixUsername, config.ZabbixPassword)
-	raw_csv := zabbix_export_csv(config.ZabbixUrl, session_cookie)
+	jar, err := load_cookie_jar(config.ZabbixUrl)
+
+	if err != nil {
+		fmt.Printf("failed to load the cookie jar, error: %s\n", err.Error())
+		os.Exit(ExitCodeErrorCookieJar)
+	}
+
+	client := &http.Client{
+		Jar: jar,
+		// prevent a redirect, golang doesn't store the cookie so during natural redirect we cause the server to respond
+		// with a cookie which with it we cannot access the zabbix as a user
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	policy := default_retry_policy(resolve_request_timeout(config.RequestTimeout))
+
+	if *flag_force_login || !has_valid_session(jar, config.ZabbixUrl) {
+		session_expiry := zabbix_login(client, policy, config.ZabbixUrl, config.ZabbixUsername, config.ZabbixPassword)
+
+		if err := save_cookie_jar(jar, config.ZabbixUrl, session_expiry); err != nil {
+			fmt.Printf("failed to persist the cookie jar, error: %s\n", err.Error())
+		}
+	}
+
+	// graphs and api mode both speak JSON-RPC, so they share a single
+	// zabbix_client and a single user.login call instead of each logging in
+	// on its own
+	var zabbix_client *zabbix.Client
+	var api_auth string
+
+	if config.Graphs.Enabled || config.Mode == "api" {
+		zabbix_client = zabbix.New_client(config.ZabbixUrl, func(req *http.Request) (*http.Response, error) {
+			return do_with_retry(client, req, policy)
+		})
+
+		api_auth, err = zabbix_client.Login(config.ZabbixUsername, config.ZabbixPassword)
+
+		if err != nil {
+			if config.Mode == "api" {
+				fmt.Printf("failed to authenticate against the JSON-RPC API, error: %s\n", err.Error())
+				os.Exit(ExitCodeErrorLoginRequest)
+			}
+
+			fmt.Printf("failed to authenticate against the JSON-RPC API, graphs will be omitted, error: %s\n", err.Error())
+		}
+	}
+
+	itemids := map[string]string{}
+
+	if config.Graphs.Enabled && api_auth != "" {
+		itemids, err = zabbix_client.Lookup_item_ids(api_auth)
+
+		if err != nil {
+			fmt.Printf("failed to resolve itemids, graphs will be omitted, error: %s\n", err.Error())
+			itemids = map[string]string{}
+		}
+	}
+
+	var attachments []email_attachment
+	use_cid := config.Graphs.Enabled && config.Smtp != nil
 
-	html_problem_table := extract_active_problems(raw_csv)
-	html_resolved_table := extract_resolved_problems(raw_csv)
+	var html_problem_table, html_resolved_table string
+
+	if config.Mode == "api" {
+		html_problem_table, html_resolved_table = build_api_report(zabbix_client, api_auth, client, policy, config, itemids, use_cid, &attachments)
+	} else {
+		raw_csv, needs_login := zabbix_export_csv(client, policy, config.ZabbixUrl)
+
+		if needs_login {
+			session_expiry := zabbix_login(client, policy, config.ZabbixUrl, config.ZabbixUsername, config.ZabbixPassword)
+
+			if err := save_cookie_jar(jar, config.ZabbixUrl, session_expiry); err != nil {
+				fmt.Printf("failed to persist the cookie jar, error: %s\n", err.Error())
+			}
+
+			raw_csv, _ = zabbix_export_csv(client, policy, config.ZabbixUrl)
+		}
+
+		html_problem_table = extract_active_problems(raw_csv, config.ZabbixUrl, client, policy, itemids, config.Graphs, use_cid, &attachments)
+		html_resolved_table = extract_resolved_problems(raw_csv)
+	}
 
 	email_html := bytes.NewBufferString("")
 
@@ -244,4 +411,8 @@ func main() {
 	// if it doesn't exist it will be created, otherwise it will fail and we can ignore the error
 	os.Mkdir("report", 0755)
 	os.WriteFile(fmt.Sprintf("report/report-%d-%d-%d-%d-%d-%d.html", time_now.Year(), time_now.Month(), time_now.Day(), time_now.Hour(), time_now.Minute(), time_now.Second()), []byte(email_html.String()), 0644)
+
+	if config.Smtp != nil {
+		send_report_email(*config.Smtp, email_html.String(), attachments)
+	}
 }
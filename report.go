@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/bizxeon/zabbix-report-batch/zabbix"
+)
+
+type TagFilterConfig struct {
+	Tag   string `yaml:"tag"`
+	Value string `yaml:"value"`
+}
+
+type FilterConfig struct {
+	HostGroups        []string          `yaml:"host_groups"`
+	MinSeverity       string            `yaml:"min_severity"`
+	TagFilter         []TagFilterConfig `yaml:"tag_filter"`
+	TimeWindowMinutes int               `yaml:"time_window_minutes"`
+}
+
+// translates config.yaml's filters block into the zabbix package's query
+// filter, resolving the time window relative to now
+func build_problem_filter(filters FilterConfig) zabbix.ProblemFilter {
+	tags := make([]zabbix.Tag, 0, len(filters.TagFilter))
+	for _, tag := range filters.TagFilter {
+		tags = append(tags, zabbix.Tag{Tag: tag.Tag, Value: tag.Value})
+	}
+
+	var time_from time.Time
+	if filters.TimeWindowMinutes > 0 {
+		time_from = time.Now().Add(-time.Duration(filters.TimeWindowMinutes) * time.Minute)
+	}
+
+	return zabbix.ProblemFilter{
+		HostGroups:  filters.HostGroups,
+		MinSeverity: zabbix.Severity_from_string(filters.MinSeverity),
+		TagFilter:   tags,
+		TimeFrom:    time_from,
+	}
+}
+
+// fetches active/resolved problems through the already-authenticated
+// zabbix_client and renders both tables. exits with the proper error code
+// if either step fails outright
+func build_api_report(zabbix_client *zabbix.Client, auth string, client *http.Client, policy RetryPolicy, config Config, itemids map[string]string, use_cid bool, attachments *[]email_attachment) (html_problem_table string, html_resolved_table string) {
+	filter := build_problem_filter(config.Filters)
+
+	active_problems, err := zabbix_client.Get_active_problems(auth, filter)
+
+	if err != nil {
+		fmt.Printf("failed to fetch active problems, error: %s\n", err.Error())
+		os.Exit(ExitCodeErrorExportRequest)
+	}
+
+	resolved_problems, err := zabbix_client.Get_resolved_problems(auth, filter)
+
+	if err != nil {
+		fmt.Printf("failed to fetch resolved problems, error: %s\n", err.Error())
+		os.Exit(ExitCodeErrorExportRequest)
+	}
+
+	var graph_tags map[string]string
+
+	if config.Graphs.Enabled {
+		graph_tags = map[string]string{}
+
+		for _, problem := range active_problems {
+			graph_tags[problem.Host+"|"+problem.Name] = graph_image_tag(config.ZabbixUrl, client, policy, itemids, problem.Host, problem.Name, config.Graphs, use_cid, attachments)
+		}
+	}
+
+	return render_problems_table(active_problems, graph_tags), render_problems_table(resolved_problems, nil)
+}
+
+func severity_name(severity int) string {
+	switch severity {
+	case zabbix.SeverityInformation:
+		return "Information"
+	case zabbix.SeverityWarning:
+		return "Warning"
+	case zabbix.SeverityAverage:
+		return "Average"
+	case zabbix.SeverityHigh:
+		return "High"
+	case zabbix.SeverityDisaster:
+		return "Disaster"
+	default:
+		return "Not classified"
+	}
+}
+
+// Zabbix's own default severity color palette
+func severity_color(severity int) string {
+	switch severity {
+	case zabbix.SeverityInformation:
+		return "#7499FF"
+	case zabbix.SeverityWarning:
+		return "#FFC859"
+	case zabbix.SeverityAverage:
+		return "#FFA059"
+	case zabbix.SeverityHigh:
+		return "#E97659"
+	case zabbix.SeverityDisaster:
+		return "#E45959"
+	default:
+		return "#97AAB3"
+	}
+}
+
+// renders problems as an HTML table, grouped by severity (Disaster first)
+// and color-coded per Zabbix's own severity palette. graph_tags, when
+// non-nil, is consulted per row (keyed "host|name") to add a Graph column
+func render_problems_table(problems []zabbix.Problem, graph_tags map[string]string) string {
+	sorted := make([]zabbix.Problem, len(problems))
+	copy(sorted, problems)
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Severity > sorted[j].Severity
+	})
+
+	result := bytes.NewBufferString("")
+
+	fmt.Fprintf(result, "<table><tr><td style=\"text-align: center;\">Severity</td><td style=\"text-align: center;\">Host</td><td style=\"text-align: center;\">Problem</td><td style=\"text-align: center;\">Ack</td><td style=\"text-align: center;\">Time</td><td style=\"text-align: center;\">Duration</td>")
+
+	if graph_tags != nil {
+		fmt.Fprintf(result, "<td style=\"text-align: center;\">Graph</td>")
+	}
+
+	fmt.Fprintf(result, "</tr>\n")
+
+	for _, problem := range sorted {
+		ack := "No"
+		if problem.Acknowledged {
+			ack = "Yes"
+		}
+
+		fmt.Fprintf(
+			result,
+			"<tr style=\"background-color: %s;\"><td>%s</td><td>%s</td><td>%s</td><td>%s</td><td>%s</td><td>%s</td>",
+			severity_color(problem.Severity), severity_name(problem.Severity), problem.Host, problem.Name, ack,
+			problem.Clock.Local().Format("2006-01-02 15:04:05"), problem.Duration.Round(time.Second),
+		)
+
+		if graph_tags != nil {
+			fmt.Fprintf(result, "<td>%s</td>", graph_tags[problem.Host+"|"+problem.Name])
+		}
+
+		fmt.Fprintf(result, "</tr>\n")
+	}
+
+	fmt.Fprintf(result, "</table>")
+
+	return result.String()
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// wraps a response body so the per-attempt context is canceled on Close
+// instead of immediately after client.Do returns, otherwise canceling the
+// context the instant headers arrive aborts body bytes that haven't been
+// read yet
+type cancel_on_close_body struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancel_on_close_body) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+const default_request_timeout = 10 * time.Second
+
+// parses config.yaml's request_timeout (e.g. "10s"), falling back to
+// default_request_timeout when it's absent or invalid
+func resolve_request_timeout(raw string) time.Duration {
+	if raw == "" {
+		return default_request_timeout
+	}
+
+	timeout, err := time.ParseDuration(raw)
+
+	if err != nil {
+		fmt.Printf("invalid request_timeout %q, falling back to %s, error: %s\n", raw, default_request_timeout, err.Error())
+		return default_request_timeout
+	}
+
+	return timeout
+}
+
+type RetryPolicy struct {
+	InitialDelay   time.Duration
+	MaxDelay       time.Duration
+	Factor         float64
+	MaxAttempts    int
+	MaxElapsed     time.Duration
+	RequestTimeout time.Duration
+}
+
+func default_retry_policy(request_timeout time.Duration) RetryPolicy {
+	return RetryPolicy{
+		InitialDelay:   500 * time.Millisecond,
+		MaxDelay:       30 * time.Second,
+		Factor:         2,
+		MaxAttempts:    10,
+		MaxElapsed:     5 * time.Minute,
+		RequestTimeout: request_timeout,
+	}
+}
+
+// executes req, retrying on network errors and 5xx/408/429 responses with
+// exponential backoff and jitter (delay = min(cap, base*factor^(attempt-1))
+// * (0.5 + rand*0.5), see backoff_delay), honoring Retry-After on 429/503. each attempt is
+// bounded by policy.RequestTimeout, and retries stop once either
+// policy.MaxAttempts or policy.MaxElapsed is reached
+func do_with_retry(client *http.Client, req *http.Request, policy RetryPolicy) (*http.Response, error) {
+	start := time.Now()
+	attempt := 0
+
+	for {
+		attempt_req := req.Clone(req.Context())
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+
+			if err != nil {
+				return nil, fmt.Errorf("failed to rewind the request body, error: %w", err)
+			}
+
+			attempt_req.Body = body
+		}
+
+		ctx, cancel := context.WithTimeout(req.Context(), policy.RequestTimeout)
+		attempt_req = attempt_req.WithContext(ctx)
+
+		response, err := client.Do(attempt_req)
+
+		retry, retry_after := should_retry_response(response, err)
+
+		if !retry {
+			// the context has to stay alive for as long as the caller reads
+			// response.Body (http ties a request's context to the whole
+			// response lifetime, not just the headers), so defer the cancel
+			// until Close rather than calling it right after Do
+			if response != nil {
+				response.Body = &cancel_on_close_body{ReadCloser: response.Body, cancel: cancel}
+			} else {
+				cancel()
+			}
+
+			return response, err
+		}
+
+		cancel()
+
+		attempt++
+		delay := backoff_delay(policy, attempt)
+
+		if retry_after > 0 {
+			delay = retry_after
+		}
+
+		if attempt >= policy.MaxAttempts || time.Since(start)+delay > policy.MaxElapsed {
+			if response != nil {
+				response.Body.Close()
+			}
+
+			if err != nil {
+				return nil, fmt.Errorf("giving up after %d attempts over %s, last error: %w", attempt, time.Since(start).Round(time.Second), err)
+			}
+
+			return nil, fmt.Errorf("giving up after %d attempts over %s, last status: %d", attempt, time.Since(start).Round(time.Second), response.StatusCode)
+		}
+
+		if response != nil {
+			response.Body.Close()
+		}
+
+		time.Sleep(delay)
+	}
+}
+
+// decides whether a response/error pair warrants a retry, and if so,
+// returns the Retry-After duration the server asked for (0 if none/unset)
+func should_retry_response(response *http.Response, err error) (bool, time.Duration) {
+	if err != nil {
+		return true, 0
+	}
+
+	if response.StatusCode == http.StatusTooManyRequests || response.StatusCode == http.StatusServiceUnavailable {
+		return true, parse_retry_after(response.Header.Get("Retry-After"))
+	}
+
+	if response.StatusCode == http.StatusRequestTimeout || response.StatusCode >= 500 {
+		return true, 0
+	}
+
+	return false, 0
+}
+
+func parse_retry_after(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if when, err := http.ParseTime(value); err == nil {
+		return time.Until(when)
+	}
+
+	return 0
+}
+
+// delay = min(cap, base * factor^(attempt-1)) * (0.5 + rand*0.5)
+func backoff_delay(policy RetryPolicy, attempt int) time.Duration {
+	raw := float64(policy.InitialDelay) * math.Pow(policy.Factor, float64(attempt-1))
+
+	if raw > float64(policy.MaxDelay) {
+		raw = float64(policy.MaxDelay)
+	}
+
+	jitter := 0.5 + rand.Float64()*0.5
+
+	return time.Duration(raw * jitter)
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+type SMTPConfig struct {
+	Host     string   `yaml:"host"`
+	Port     int      `yaml:"port"`
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+	// e.g. "Zabbix report - {{.Date}}"
+	SubjectTemplate string `yaml:"subject_template"`
+	TLSMode         string `yaml:"tls_mode"` // starttls|tls|none
+}
+
+// data made available to subject_template
+type subject_data struct {
+	Date string
+}
+
+// renders cfg.SubjectTemplate as a text/template, falling back to the raw
+// string on a parse/execute error so a bad template doesn't block delivery
+func render_subject(subject_template string) string {
+	tmpl, err := template.New("subject").Parse(subject_template)
+
+	if err != nil {
+		fmt.Printf("invalid subject_template %q, using it verbatim, error: %s\n", subject_template, err.Error())
+		return subject_template
+	}
+
+	rendered := bytes.NewBufferString("")
+	data := subject_data{Date: time.Now().Local().Format("2006-01-02 15:04")}
+
+	if err := tmpl.Execute(rendered, data); err != nil {
+		fmt.Printf("failed to render subject_template %q, using it verbatim, error: %s\n", subject_template, err.Error())
+		return subject_template
+	}
+
+	return rendered.String()
+}
+
+// a graph PNG waiting to be attached to the outgoing email, referenced
+// from the HTML body as cid:ContentID
+type email_attachment struct {
+	ContentID string
+	Data      []byte
+}
+
+// inserts "\r\n" every 76 bytes, the line-length limit RFC 2045 places on
+// base64-encoded body parts
+type line76_writer struct {
+	w   io.Writer
+	col int
+}
+
+func (lw *line76_writer) Write(p []byte) (int, error) {
+	written := 0
+
+	for len(p) > 0 {
+		chunk := p
+		if remaining := 76 - lw.col; len(chunk) > remaining {
+			chunk = chunk[:remaining]
+		}
+
+		n, err := lw.w.Write(chunk)
+		written += n
+		lw.col += n
+		p = p[n:]
+
+		if err != nil {
+			return written, err
+		}
+
+		if lw.col == 76 {
+			if _, err := lw.w.Write([]byte("\r\n")); err != nil {
+				return written, err
+			}
+
+			lw.col = 0
+		}
+	}
+
+	return written, nil
+}
+
+var html_tag_re = regexp.MustCompile(`<[^>]*>`)
+var row_close_re = regexp.MustCompile(`(?i)</tr\s*>`)
+var cell_close_re = regexp.MustCompile(`(?i)</(td|p)\s*>`)
+
+// strips HTML tags to build the plain-text fallback part of the email,
+// turning row/cell boundaries into newlines/spaces first so adjacent
+// table cells don't end up glued together
+func strip_html_tags(html string) string {
+	with_breaks := row_close_re.ReplaceAllString(cell_close_re.ReplaceAllString(html, " "), "\n")
+
+	return strings.TrimSpace(html_tag_re.ReplaceAllString(with_breaks, ""))
+}
+
+// builds the multipart/alternative (plain text + HTML) body, wrapped in a
+// multipart/related part when attachments are present
+func build_mime_message(cfg SMTPConfig, html string, attachments []email_attachment) []byte {
+	plain_text := strip_html_tags(html)
+
+	alternative_body := bytes.NewBufferString("")
+	alternative_writer := multipart.NewWriter(alternative_body)
+
+	text_part, _ := alternative_writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {"text/plain; charset=\"utf-8\""},
+	})
+	text_part.Write([]byte(plain_text))
+
+	html_part, _ := alternative_writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {"text/html; charset=\"utf-8\""},
+	})
+	html_part.Write([]byte(html))
+
+	alternative_writer.Close()
+
+	message := bytes.NewBufferString("")
+	fmt.Fprintf(message, "From: %s\r\n", cfg.From)
+	fmt.Fprintf(message, "To: %s\r\n", strings.Join(cfg.To, ", "))
+	fmt.Fprintf(message, "Subject: %s\r\n", render_subject(cfg.SubjectTemplate))
+	fmt.Fprintf(message, "MIME-Version: 1.0\r\n")
+
+	if len(attachments) == 0 {
+		fmt.Fprintf(message, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", alternative_writer.Boundary())
+		message.Write(alternative_body.Bytes())
+
+		return message.Bytes()
+	}
+
+	related_body := bytes.NewBufferString("")
+	related_writer := multipart.NewWriter(related_body)
+
+	alternative_part, _ := related_writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {fmt.Sprintf("multipart/alternative; boundary=%s", alternative_writer.Boundary())},
+	})
+	alternative_part.Write(alternative_body.Bytes())
+
+	for _, attachment := range attachments {
+		image_part, _ := related_writer.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {"image/png"},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-ID":                {fmt.Sprintf("<%s>", attachment.ContentID)},
+		})
+
+		encoder := base64.NewEncoder(base64.StdEncoding, &line76_writer{w: image_part})
+		encoder.Write(attachment.Data)
+		encoder.Close()
+	}
+
+	related_writer.Close()
+
+	fmt.Fprintf(message, "Content-Type: multipart/related; boundary=%s\r\n\r\n", related_writer.Boundary())
+	message.Write(related_body.Bytes())
+
+	return message.Bytes()
+}
+
+// sends the report over SMTP as a multipart/alternative message, with the
+// graph PNGs attached as cid: referenced multipart/related parts when
+// attachments is non-empty. exits with the proper error code on failure
+func send_report_email(cfg SMTPConfig, html string, attachments []email_attachment) {
+	message := build_mime_message(cfg, html, attachments)
+	addr := fmt.Sprintf("%s:%d", cfg.Host, cfg.Port)
+
+	var client *smtp.Client
+	var err error
+
+	if cfg.TLSMode == "tls" {
+		conn, dial_err := tls.Dial("tcp", addr, &tls.Config{ServerName: cfg.Host})
+
+		if dial_err != nil {
+			fmt.Printf("failed to connect to %s, error: %s\n", addr, dial_err.Error())
+			os.Exit(ExitCodeErrorSMTPConnect)
+		}
+
+		client, err = smtp.NewClient(conn, cfg.Host)
+	} else {
+		client, err = smtp.Dial(addr)
+	}
+
+	if err != nil {
+		fmt.Printf("failed to connect to %s, error: %s\n", addr, err.Error())
+		os.Exit(ExitCodeErrorSMTPConnect)
+	}
+
+	defer client.Close()
+
+	if cfg.TLSMode == "starttls" {
+		err = client.StartTLS(&tls.Config{ServerName: cfg.Host})
+
+		if err != nil {
+			fmt.Printf("failed to start TLS with %s, error: %s\n", addr, err.Error())
+			os.Exit(ExitCodeErrorSMTPConnect)
+		}
+	}
+
+	if cfg.Username != "" {
+		err = client.Auth(smtp.PlainAuth("", cfg.Username, cfg.Password, cfg.Host))
+
+		if err != nil {
+			fmt.Printf("failed to authenticate with %s, error: %s\n", addr, err.Error())
+			os.Exit(ExitCodeErrorSMTPConnect)
+		}
+	}
+
+	err = client.Mail(cfg.From)
+
+	if err != nil {
+		fmt.Printf("failed to send the report email, error: %s\n", err.Error())
+		os.Exit(ExitCodeErrorSMTPSend)
+	}
+
+	for _, to := range cfg.To {
+		err = client.Rcpt(to)
+
+		if err != nil {
+			fmt.Printf("failed to send the report email, error: %s\n", err.Error())
+			os.Exit(ExitCodeErrorSMTPSend)
+		}
+	}
+
+	writer, err := client.Data()
+
+	if err != nil {
+		fmt.Printf("failed to send the report email, error: %s\n", err.Error())
+		os.Exit(ExitCodeErrorSMTPSend)
+	}
+
+	_, err = writer.Write(message)
+
+	if err != nil {
+		fmt.Printf("failed to send the report email, error: %s\n", err.Error())
+		os.Exit(ExitCodeErrorSMTPSend)
+	}
+
+	err = writer.Close()
+
+	if err != nil {
+		fmt.Printf("failed to send the report email, error: %s\n", err.Error())
+		os.Exit(ExitCodeErrorSMTPSend)
+	}
+
+	client.Quit()
+}
@@ -0,0 +1,93 @@
+package zabbix
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+type itemRef struct {
+	Itemid string `json:"itemid"`
+}
+
+// Lookup_item_ids fetches the currently open problems via problem.get, walks
+// each one's trigger via trigger.get to find its itemid, and returns a
+// lookup keyed by "host|problem name" so graph_image_tag can attach a
+// graph. the join runs through each trigger's triggerid rather than its
+// description, since trigger.get's description is the raw, unexpanded
+// trigger name template (e.g. "CPU load is too high on {HOST.NAME}")
+// while callers key on the macro-resolved name problem.get already hands
+// back
+func (c *Client) Lookup_item_ids(auth string) (map[string]string, error) {
+	raw_problems, err := c.call(auth, "problem.get", map[string]interface{}{
+		"output":             "extend",
+		"selectAcknowledges": "extend",
+		"recent":             true,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []struct {
+		Objectid string `json:"objectid"`
+		Name     string `json:"name"`
+	}
+
+	err = json.Unmarshal(raw_problems, &problems)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode problem.get result, error: %w", err)
+	}
+
+	triggerids := make([]string, 0, len(problems))
+	name_by_triggerid := make(map[string]string, len(problems))
+
+	for _, problem := range problems {
+		triggerids = append(triggerids, problem.Objectid)
+		name_by_triggerid[problem.Objectid] = problem.Name
+	}
+
+	raw_triggers, err := c.call(auth, "trigger.get", map[string]interface{}{
+		"output":      "extend",
+		"selectItems": "extend",
+		"selectHosts": "extend",
+		"triggerids":  triggerids,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	var triggers []struct {
+		Triggerid string    `json:"triggerid"`
+		Items     []itemRef `json:"items"`
+		Hosts     []struct {
+			Host string `json:"host"`
+		} `json:"hosts"`
+	}
+
+	err = json.Unmarshal(raw_triggers, &triggers)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode trigger.get result, error: %w", err)
+	}
+
+	lookup := make(map[string]string)
+
+	for _, trigger := range triggers {
+		if len(trigger.Items) == 0 || len(trigger.Hosts) == 0 {
+			continue
+		}
+
+		name, ok := name_by_triggerid[trigger.Triggerid]
+
+		if !ok {
+			continue
+		}
+
+		key := trigger.Hosts[0].Host + "|" + name
+		lookup[key] = trigger.Items[0].Itemid
+	}
+
+	return lookup, nil
+}
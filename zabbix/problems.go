@@ -0,0 +1,331 @@
+package zabbix
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	SeverityNotClassified = 0
+	SeverityInformation   = 1
+	SeverityWarning       = 2
+	SeverityAverage       = 3
+	SeverityHigh          = 4
+	SeverityDisaster      = 5
+)
+
+// Severity_from_string maps config.yaml's min_severity ("information",
+// "warning", "average", "high", "disaster") to the numeric Zabbix
+// severity, defaulting to SeverityNotClassified for anything unrecognised
+func Severity_from_string(name string) int {
+	switch strings.ToLower(name) {
+	case "information":
+		return SeverityInformation
+	case "warning":
+		return SeverityWarning
+	case "average":
+		return SeverityAverage
+	case "high":
+		return SeverityHigh
+	case "disaster":
+		return SeverityDisaster
+	default:
+		return SeverityNotClassified
+	}
+}
+
+type Tag struct {
+	Tag   string `json:"tag"`
+	Value string `json:"value"`
+}
+
+// Problem is the typed replacement for the positional CSV rows
+// problem.view.csv used to produce
+type Problem struct {
+	Host         string
+	Name         string
+	Severity     int
+	OpData       string
+	Acknowledged bool
+	Tags         []Tag
+	Clock        time.Time
+	RClock       time.Time
+	Duration     time.Duration
+}
+
+type ProblemFilter struct {
+	HostGroups  []string
+	MinSeverity int
+	TagFilter   []Tag
+	TimeFrom    time.Time
+	TimeTill    time.Time
+}
+
+// resolves human host group names to the groupids problem.get/event.get
+// expect; returns nil (no filtering) when names is empty
+func (c *Client) resolve_host_group_ids(auth string, names []string) ([]string, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	raw, err := c.call(auth, "hostgroup.get", map[string]interface{}{
+		"output": []string{"groupid"},
+		"filter": map[string]interface{}{"name": names},
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []struct {
+		Groupid string `json:"groupid"`
+	}
+
+	err = json.Unmarshal(raw, &groups)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode hostgroup.get result, error: %w", err)
+	}
+
+	groupids := make([]string, 0, len(groups))
+	for _, group := range groups {
+		groupids = append(groupids, group.Groupid)
+	}
+
+	return groupids, nil
+}
+
+type rawProblem struct {
+	Eventid      string `json:"eventid"`
+	Objectid     string `json:"objectid"`
+	Name         string `json:"name"`
+	Severity     string `json:"severity"`
+	Clock        string `json:"clock"`
+	Acknowledged string `json:"acknowledged"`
+	Tags         []Tag  `json:"tags"`
+}
+
+type rawTrigger struct {
+	Triggerid string `json:"triggerid"`
+	Opdata    string `json:"opdata"`
+	Hosts     []struct {
+		Host string `json:"host"`
+	} `json:"hosts"`
+}
+
+// looks up, per triggerid, the host and opdata trigger.get alone exposes
+func (c *Client) resolve_triggers(auth string, triggerids []string) (map[string]rawTrigger, error) {
+	if len(triggerids) == 0 {
+		return map[string]rawTrigger{}, nil
+	}
+
+	raw, err := c.call(auth, "trigger.get", map[string]interface{}{
+		"output":      "extend",
+		"selectHosts": "extend",
+		"triggerids":  triggerids,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	var triggers []rawTrigger
+	err = json.Unmarshal(raw, &triggers)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode trigger.get result, error: %w", err)
+	}
+
+	by_id := make(map[string]rawTrigger, len(triggers))
+	for _, trigger := range triggers {
+		by_id[trigger.Triggerid] = trigger
+	}
+
+	return by_id, nil
+}
+
+func build_problem_get_params(filter ProblemFilter, groupids []string) map[string]interface{} {
+	params := map[string]interface{}{
+		"output":     "extend",
+		"selectTags": "extend",
+		"recent":     true,
+	}
+
+	if groupids != nil {
+		params["groupids"] = groupids
+	}
+
+	if filter.MinSeverity > SeverityNotClassified {
+		severities := make([]int, 0, SeverityDisaster-filter.MinSeverity+1)
+		for severity := filter.MinSeverity; severity <= SeverityDisaster; severity++ {
+			severities = append(severities, severity)
+		}
+		params["severities"] = severities
+	}
+
+	if len(filter.TagFilter) > 0 {
+		params["tags"] = filter.TagFilter
+	}
+
+	if !filter.TimeFrom.IsZero() {
+		params["time_from"] = filter.TimeFrom.Unix()
+	}
+
+	if !filter.TimeTill.IsZero() {
+		params["time_till"] = filter.TimeTill.Unix()
+	}
+
+	return params
+}
+
+// Get_active_problems fetches the currently open problems via problem.get,
+// resolving each one's host/opdata via trigger.get
+func (c *Client) Get_active_problems(auth string, filter ProblemFilter) ([]Problem, error) {
+	groupids, err := c.resolve_host_group_ids(auth, filter.HostGroups)
+
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := c.call(auth, "problem.get", build_problem_get_params(filter, groupids))
+
+	if err != nil {
+		return nil, err
+	}
+
+	var raw_problems []rawProblem
+	err = json.Unmarshal(raw, &raw_problems)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode problem.get result, error: %w", err)
+	}
+
+	triggerids := make([]string, 0, len(raw_problems))
+	for _, raw_problem := range raw_problems {
+		triggerids = append(triggerids, raw_problem.Objectid)
+	}
+
+	triggers, err := c.resolve_triggers(auth, triggerids)
+
+	if err != nil {
+		return nil, err
+	}
+
+	problems := make([]Problem, 0, len(raw_problems))
+
+	for _, raw_problem := range raw_problems {
+		trigger, ok := triggers[raw_problem.Objectid]
+
+		if !ok || len(trigger.Hosts) == 0 {
+			continue
+		}
+
+		problems = append(problems, problem_from_raw(raw_problem, trigger))
+	}
+
+	return problems, nil
+}
+
+// Get_resolved_problems fetches recently resolved trigger events via
+// event.get, since problem.get only ever reports the currently open set
+func (c *Client) Get_resolved_problems(auth string, filter ProblemFilter) ([]Problem, error) {
+	groupids, err := c.resolve_host_group_ids(auth, filter.HostGroups)
+
+	if err != nil {
+		return nil, err
+	}
+
+	params := map[string]interface{}{
+		"output":     "extend",
+		"selectTags": "extend",
+		"object":     0, // trigger
+		"source":     0, // trigger event
+		"value":      0, // OK, i.e. resolved
+	}
+
+	if groupids != nil {
+		params["groupids"] = groupids
+	}
+
+	if len(filter.TagFilter) > 0 {
+		params["tags"] = filter.TagFilter
+	}
+
+	if !filter.TimeFrom.IsZero() {
+		params["time_from"] = filter.TimeFrom.Unix()
+	}
+
+	if !filter.TimeTill.IsZero() {
+		params["time_till"] = filter.TimeTill.Unix()
+	}
+
+	raw, err := c.call(auth, "event.get", params)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var raw_events []struct {
+		rawProblem
+		RClock string `json:"r_clock"`
+	}
+
+	err = json.Unmarshal(raw, &raw_events)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode event.get result, error: %w", err)
+	}
+
+	triggerids := make([]string, 0, len(raw_events))
+	for _, raw_event := range raw_events {
+		triggerids = append(triggerids, raw_event.Objectid)
+	}
+
+	triggers, err := c.resolve_triggers(auth, triggerids)
+
+	if err != nil {
+		return nil, err
+	}
+
+	problems := make([]Problem, 0, len(raw_events))
+
+	for _, raw_event := range raw_events {
+		trigger, ok := triggers[raw_event.Objectid]
+
+		if !ok || len(trigger.Hosts) == 0 {
+			continue
+		}
+
+		if raw_event.Severity == "" {
+			raw_event.Severity = "0"
+		}
+
+		problem := problem_from_raw(raw_event.rawProblem, trigger)
+
+		if r_clock, err := parse_unix(raw_event.RClock); err == nil {
+			problem.RClock = r_clock
+			problem.Duration = r_clock.Sub(problem.Clock)
+		}
+
+		problems = append(problems, problem)
+	}
+
+	return problems, nil
+}
+
+func problem_from_raw(raw_problem rawProblem, trigger rawTrigger) Problem {
+	clock, _ := parse_unix(raw_problem.Clock)
+
+	return Problem{
+		Host:         trigger.Hosts[0].Host,
+		Name:         raw_problem.Name,
+		Severity:     atoi_or_zero(raw_problem.Severity),
+		OpData:       trigger.Opdata,
+		Acknowledged: raw_problem.Acknowledged == "1",
+		Tags:         raw_problem.Tags,
+		Clock:        clock,
+		Duration:     time.Since(clock),
+	}
+}
@@ -0,0 +1,29 @@
+package zabbix
+
+import (
+	"strconv"
+	"time"
+)
+
+// the Zabbix API returns numeric fields as strings; these helpers decode
+// them without forcing every call site to handle the error itself
+
+func atoi_or_zero(value string) int {
+	parsed, err := strconv.Atoi(value)
+
+	if err != nil {
+		return 0
+	}
+
+	return parsed
+}
+
+func parse_unix(value string) (time.Time, error) {
+	seconds, err := strconv.ParseInt(value, 10, 64)
+
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Unix(seconds, 0), nil
+}
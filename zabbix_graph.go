@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// fetches the PNG rendering of a single item's graph from chart.php, using
+// the shared client's session cookie jar
+func zabbix_fetch_graph(client *http.Client, policy RetryPolicy, zabbix_url string, itemid string, from time.Time, to time.Time, width int, height int) ([]byte, error) {
+	url := fmt.Sprintf(
+		"%s/chart.php?itemids=%s&width=%d&height=%d&from=%d&to=%d&profileIdx=web.item.graph",
+		zabbix_url, itemid, width, height, from.Unix(), to.Unix(),
+	)
+
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to build the chart.php request, error: %w", err)
+	}
+
+	response, err := do_with_retry(client, request, policy)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch the graph for item %s, error: %w", itemid, err)
+	}
+
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("chart.php returned status %d for item %s", response.StatusCode, itemid)
+	}
+
+	body, err := io.ReadAll(response.Body)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the graph body for item %s, error: %w", itemid, err)
+	}
+
+	return body, nil
+}